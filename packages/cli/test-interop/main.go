@@ -1,37 +1,499 @@
 package main
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/agentuity/go-common/crypto"
+	"github.com/agentuity/sdk/packages/crypto/kemdem"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/term"
 )
 
+const (
+	pemTypeECPrivateKey = "EC PRIVATE KEY"
+	// pemTypeEncryptedPrivateKey is the reserved PKCS#8 type: the DER inside is a real
+	// EncryptedPrivateKeyInfo/PBES2 structure (PBKDF2-HMAC-SHA256 + AES-256-CBC, see
+	// encryptedPrivateKeyInfo), so openssl and other PKCS#8 tooling can read it directly, e.g.
+	// `openssl pkey -in key.pem -passin pass:...`.
+	pemTypeEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+
+	defaultMinPassphraseLength = 12
+	pbkdf2Iterations           = 600000
+	pbkdf2SaltSize             = 16
+	aesKeySize                 = 32
+
+	defaultCurveName = "P-256"
+)
+
+// PKCS#8 PBES2 object identifiers (RFC 8018).
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// pbkdf2HashByOID maps a PBKDF2 PRF AlgorithmIdentifier to its hash constructor. A nil/empty OID
+// means the PRF field was absent, which per RFC 8018 defaults to hmacWithSHA1.
+func pbkdf2HashByOID(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case len(oid) == 0 || oid.Equal(oidHMACSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidHMACSHA256):
+		return sha256.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 PRF %s", oid)
+	}
+}
+
+// curveIDs assigns each allowed curve a one-byte identifier that is written to the ciphertext
+// stream ahead of the KEM/DEM payload, so decrypt can verify the private key matches the curve
+// that was actually used to encrypt without guessing from key size alone.
+var curveIDs = map[string]byte{
+	"P-256": 1,
+	"P-384": 2,
+	"P-521": 3,
+}
+
+// curvesByName lists the curves encrypt/decrypt/keygen accept, keyed by their elliptic.CurveParams name.
+var curvesByName = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// curveByID returns the curve registered under id, or false if id is unrecognized.
+func curveByID(id byte) (elliptic.Curve, bool) {
+	for name, curveID := range curveIDs {
+		if curveID == id {
+			return curvesByName[name], true
+		}
+	}
+	return nil, false
+}
+
+// idForCurve returns the one-byte identifier for curve, or false if curve isn't in the allow-list.
+func idForCurve(curve elliptic.Curve) (byte, bool) {
+	id, ok := curveIDs[curve.Params().Name]
+	return id, ok
+}
+
+// Frame modes follow the curve identifier byte and select how the remainder of the stream is
+// encoded: frameModeStream delegates to crypto.EncryptFIPSKEMDEMStream/DecryptFIPSKEMDEMStream,
+// which only ever accepts P-256 recipients; frameModeKemdem delegates to the local kemdem package,
+// which extends that same envelope scheme to P-384/P-521 and is also used for P-256 when --chunked
+// requests a non-default frame size.
+const (
+	frameModeStream byte = 0
+	frameModeKemdem byte = 1
+
+	defaultChunkSize = 64 * 1024
+)
+
+// parseECDSAPublicKeyPEM extracts an ECDSA public key from a decoded PEM block. It accepts a bare
+// PKIX "PUBLIC KEY" block, or a "CERTIFICATE"/"CERTIFICATE REQUEST" block, pulling the public key
+// out of the leaf certificate or CSR respectively. This lets callers pipe a certificate or CSR
+// straight from a PKI without a manual "extract pubkey" step.
+func parseECDSAPublicKeyPEM(block *pem.Block) (*ecdsa.PublicKey, error) {
+	var pubKey any
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		pubKey = cert.PublicKey
+	case "CERTIFICATE REQUEST":
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate request: %w", err)
+		}
+		pubKey = csr.PublicKey
+	default:
+		var err error
+		pubKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+	}
+
+	ecPub, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA public key")
+	}
+	return ecPub, nil
+}
+
+// loadPrivateKeyPEM decodes a base64-encoded private key PEM (plain or passphrase-encrypted) and
+// returns the underlying ECDSA private key, prompting for a passphrase via f/env/stdin if needed.
+func loadPrivateKeyPEM(b64 string, f flags) (*ecdsa.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode private key: %w", err)
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block")
+	}
+
+	der := block.Bytes
+	if block.Type == pemTypeEncryptedPrivateKey {
+		passphrase, err := resolvePassphrase(f, "Enter passphrase for private key: ")
+		if err != nil {
+			return nil, err
+		}
+		der, err = decryptPrivateKeyDER(block.Bytes, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+	}
+
+	return parseAnyPrivateKey(der)
+}
+
+// parseAnyPrivateKey accepts the mix of EC private key DER encodings produced by openssl, Go, and
+// HSM exports: SEC1 (x509.ParseECPrivateKey) and PKCS#8 (x509.ParsePKCS8PrivateKey, unwrapped to
+// *ecdsa.PrivateKey). If neither format parses, or the PKCS#8 key isn't ECDSA, it returns a single
+// error describing what was tried rather than leaving the caller to guess the format.
+func parseAnyPrivateKey(der []byte) (*ecdsa.PrivateKey, error) {
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	} else if key8, err8 := x509.ParsePKCS8PrivateKey(der); err8 == nil {
+		switch k := key8.(type) {
+		case *ecdsa.PrivateKey:
+			return k, nil
+		case *rsa.PrivateKey:
+			return nil, errors.New("found an RSA key in PKCS#8 container, expected ECDSA")
+		case ed25519.PrivateKey:
+			return nil, errors.New("found an Ed25519 key in PKCS#8 container, expected ECDSA")
+		default:
+			return nil, fmt.Errorf("found an unsupported key type %T in PKCS#8 container", key8)
+		}
+	} else {
+		return nil, fmt.Errorf("not a recognized private key format: tried SEC1 (%v), tried PKCS#8 (%v)", err, err8)
+	}
+}
+
 type KeyPair struct {
+	Curve      string `json:"curve"`
 	PublicPEM  string `json:"publicPEM"`
 	PrivatePEM string `json:"privatePEM"`
 }
 
-func generateKeyPair() (*ecdsa.PrivateKey, error) {
-	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// signatureEnvelope is the self-describing JSON form of a detached signature produced by `sign
+// --detached`, so `verify` (or another tool) doesn't need out-of-band knowledge of the algorithm.
+type signatureEnvelope struct {
+	Alg   string `json:"alg"`
+	Curve string `json:"curve"`
+	Sig   string `json:"sig"`
+	Hash  string `json:"hash"`
+}
+
+// algorithmIdentifier is the generic PKIX AlgorithmIdentifier (RFC 5280 section 4.1.1.2), reused
+// here for PBES2's two sub-algorithms as well as the outer EncryptedPrivateKeyInfo.
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+// pbkdf2Params is PBKDF2-params (RFC 8018 appendix A.2), restricted to the "specified OCTET
+// STRING" salt choice this tool always produces. KeyLength and PRF are both OPTIONAL per the
+// RFC (PRF defaults to hmacWithSHA1), so keys produced by other PKCS#8 tooling that omit either
+// field must still parse, even though this tool always writes both explicitly.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                 `asn1:"optional"`
+	PRF            algorithmIdentifier `asn1:"optional"`
+}
+
+// pbes2Params is PBES2-params (RFC 8018 appendix A.4): a key-derivation AlgorithmIdentifier
+// (always PBKDF2 here) and an encryption-scheme AlgorithmIdentifier (always AES-256-CBC here).
+type pbes2Params struct {
+	KeyDerivationFunc algorithmIdentifier
+	EncryptionScheme  algorithmIdentifier
+}
+
+// encryptedPrivateKeyInfo is the real PKCS#8 EncryptedPrivateKeyInfo structure (RFC 5958 section
+// 3): an AlgorithmIdentifier describing how EncryptedData was produced, plus the ciphertext
+// itself. This tool only ever writes/reads PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC, so
+// openssl and other PKCS#8 consumers can decrypt it without any tool-specific knowledge.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+// flags holds parsed --name[=value] style command-line options.
+type flags map[string]string
+
+// parseArgs splits a command's remaining arguments into positional arguments and --flag[=value]
+// options. A bare "--flag" followed by a value that doesn't itself look like a flag is treated as
+// "--flag value"; otherwise it's treated as a boolean flag with value "true".
+func parseArgs(args []string) ([]string, flags) {
+	pos := make([]string, 0, len(args))
+	f := make(flags)
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if !strings.HasPrefix(a, "--") {
+			pos = append(pos, a)
+			continue
+		}
+		name := strings.TrimPrefix(a, "--")
+		if eq := strings.IndexByte(name, '='); eq >= 0 {
+			f[name[:eq]] = name[eq+1:]
+			continue
+		}
+		if i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
+			f[name] = args[i+1]
+			i++
+		} else {
+			f[name] = "true"
+		}
+	}
+	return pos, f
+}
+
+// resolvePassphrase returns the passphrase to use, preferring an explicit --passphrase flag, then
+// the AGENTUITY_KEY_PASSPHRASE env var, then an interactive prompt on stderr.
+func resolvePassphrase(f flags, prompt string) (string, error) {
+	if p, ok := f["passphrase"]; ok {
+		return p, nil
+	}
+	if p := os.Getenv("AGENTUITY_KEY_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return string(b), nil
+}
+
+// validatePassphrase rejects passphrases shorter than the configured minimum, which defaults to
+// defaultMinPassphraseLength but can be loosened or tightened with --min-passphrase-length.
+func validatePassphrase(passphrase string, f flags) error {
+	minLen := defaultMinPassphraseLength
+	if v, ok := f["min-passphrase-length"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid --min-passphrase-length: %w", err)
+		}
+		minLen = n
+	}
+	if len(passphrase) < minLen {
+		return fmt.Errorf("passphrase must be at least %d characters", minLen)
+	}
+	return nil
 }
 
-func exportKeyPair(priv *ecdsa.PrivateKey) (*KeyPair, error) {
+// zero overwrites b with zero bytes, best-effort, so derived key material doesn't linger in memory.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// asn1Null is the DER encoding of ASN.1 NULL, used as the (absent) parameters of the
+// hmacWithSHA256 AlgorithmIdentifier per RFC 8018's PBKDF2-params.
+var asn1Null = asn1.RawValue{FullBytes: []byte{0x05, 0x00}}
+
+// pkcs7Pad right-pads data to a multiple of blockSize per PKCS#7 (RFC 8018 uses this padding for
+// its CBC-based encryption schemes).
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padLen := blockSize - len(data)%blockSize
+	padded := make([]byte, len(data)+padLen)
+	copy(padded, data)
+	for i := len(data); i < len(padded); i++ {
+		padded[i] = byte(padLen)
+	}
+	return padded
+}
+
+// pkcs7Unpad reverses pkcs7Pad, validating the padding bytes so corrupted or wrong-key decrypts
+// are caught here instead of surfacing as a confusing downstream ASN.1 parse error.
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 || len(data)%blockSize != 0 {
+		return nil, errors.New("invalid padding: not a multiple of the block size")
+	}
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > blockSize || padLen > len(data) {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range data[len(data)-padLen:] {
+		if int(b) != padLen {
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return data[:len(data)-padLen], nil
+}
+
+// encryptPrivateKeyDER wraps der (typically a SEC1 EC private key) with a passphrase-derived key
+// using PBKDF2-HMAC-SHA256 + AES-256-CBC, returning the DER encoding of a real PKCS#8
+// EncryptedPrivateKeyInfo/PBES2 structure (see encryptedPrivateKeyInfo).
+func encryptPrivateKeyDER(der []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, pbkdf2SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+
+	key := pbkdf2.Key([]byte(passphrase), salt, pbkdf2Iterations, aesKeySize, sha256.New)
+	defer zero(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	padded := pkcs7Pad(der, aes.BlockSize)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		KeyLength:      aesKeySize,
+		PRF:            algorithmIdentifier{Algorithm: oidHMACSHA256, Parameters: asn1Null},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PBKDF2 params: %w", err)
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal IV: %w", err)
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: algorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PBES2 params: %w", err)
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData: ciphertext,
+	})
+}
+
+// decryptPrivateKeyDER reverses encryptPrivateKeyDER, returning the original inner DER bytes. It
+// only understands PBES2 with PBKDF2/HMAC-SHA256 and AES-256-CBC; anything else (a different
+// PKCS#8 encryption scheme entirely) is reported rather than guessed at.
+func decryptPrivateKeyDER(der []byte, passphrase string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported PKCS#8 encryption algorithm %s, expected PBES2", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 params: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s, expected PBKDF2", params.KeyDerivationFunc.Algorithm)
+	}
+	if !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		return nil, fmt.Errorf("unsupported encryption scheme %s, expected AES-256-CBC", params.EncryptionScheme.Algorithm)
+	}
+
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("failed to parse PBKDF2 params: %w", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("failed to parse AES-256-CBC IV: %w", err)
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid AES-256-CBC IV length %d", len(iv))
+	}
+
+	prfHash, err := pbkdf2HashByOID(kdf.PRF.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	keyLength := kdf.KeyLength
+	if keyLength == 0 {
+		keyLength = aesKeySize
+	}
+
+	key := pbkdf2.Key([]byte(passphrase), kdf.Salt, kdf.IterationCount, keyLength, prfHash)
+	defer zero(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, errors.New("failed to decrypt private key: wrong passphrase or corrupted data")
+	}
+	padded := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(padded, info.EncryptedData)
+
+	plaintext, err := pkcs7Unpad(padded, aes.BlockSize)
+	if err != nil {
+		return nil, errors.New("failed to decrypt private key: wrong passphrase or corrupted data")
+	}
+	return plaintext, nil
+}
+
+func generateKeyPair(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(curve, rand.Reader)
+}
+
+// exportKeyPair marshals priv into a KeyPair, recording its curve name so downstream tooling can
+// dispatch correctly. When passphrase is non-empty, the private PEM is a passphrase-encrypted
+// pemTypeEncryptedPrivateKey block instead of a bare "EC PRIVATE KEY" block.
+func exportKeyPair(priv *ecdsa.PrivateKey, passphrase string) (*KeyPair, error) {
 	privBytes, err := x509.MarshalECPrivateKey(priv)
 	if err != nil {
 		return nil, err
 	}
-	privPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: privBytes,
-	})
+
+	privBlock := &pem.Block{Type: pemTypeECPrivateKey, Bytes: privBytes}
+	if passphrase != "" {
+		// PKCS#8's EncryptedPrivateKeyInfo decrypts to a PrivateKeyInfo (PKCS#8), not a bare SEC1
+		// key, so openssl and other PKCS#8 tooling expect that on the other side of PBES2.
+		pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal PKCS#8 private key: %w", err)
+		}
+		encBytes, err := encryptPrivateKeyDER(pkcs8Bytes, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+		}
+		privBlock = &pem.Block{Type: pemTypeEncryptedPrivateKey, Bytes: encBytes}
+	}
+	privPEM := pem.EncodeToMemory(privBlock)
 
 	pubBytes, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
 	if err != nil {
@@ -43,6 +505,7 @@ func exportKeyPair(priv *ecdsa.PrivateKey) (*KeyPair, error) {
 	})
 
 	return &KeyPair{
+		Curve:      priv.Curve.Params().Name,
 		PublicPEM:  string(pubPEM),
 		PrivatePEM: string(privPEM),
 	}, nil
@@ -52,9 +515,11 @@ func main() {
 	if len(os.Args) < 2 {
 		fmt.Fprintf(os.Stderr, "Usage: %s <command>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Commands:\n")
-		fmt.Fprintf(os.Stderr, "  keygen              - Generate P-256 key pair (JSON output)\n")
-		fmt.Fprintf(os.Stderr, "  encrypt <pubkey>    - Encrypt stdin to stdout using base64 public key PEM\n")
-		fmt.Fprintf(os.Stderr, "  decrypt <privkey>   - Decrypt stdin to stdout using base64 private key PEM\n")
+		fmt.Fprintf(os.Stderr, "  keygen [--curve C] [--passphrase P]  - Generate a key pair (JSON output) on P-256/P-384/P-521, optionally encrypting the private PEM\n")
+		fmt.Fprintf(os.Stderr, "  encrypt <pubkey> [--chunked[=N]]  - Encrypt stdin to stdout using a base64 public key/certificate/CSR PEM\n")
+		fmt.Fprintf(os.Stderr, "  decrypt <privkey>        - Decrypt stdin to stdout using base64 private key PEM (auto-detects --chunked streams)\n")
+		fmt.Fprintf(os.Stderr, "  sign <privkey> [--raw] [--detached]  - Sign stdin (SHA-256) with base64 private key PEM\n")
+		fmt.Fprintf(os.Stderr, "  verify <pubkey> <sig>    - Verify stdin (SHA-256) against a base64 public key PEM and base64 signature\n")
 		os.Exit(1)
 	}
 
@@ -62,13 +527,38 @@ func main() {
 
 	switch cmd {
 	case "keygen":
-		priv, err := generateKeyPair()
+		_, f := parseArgs(os.Args[2:])
+
+		curveName := f["curve"]
+		if curveName == "" {
+			curveName = defaultCurveName
+		}
+		curve, ok := curvesByName[curveName]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unsupported curve %q (supported: P-256, P-384, P-521)\n", curveName)
+			os.Exit(1)
+		}
+
+		passphrase, hasPassphrase := f["passphrase"]
+		if !hasPassphrase {
+			if envPassphrase := os.Getenv("AGENTUITY_KEY_PASSPHRASE"); envPassphrase != "" {
+				passphrase, hasPassphrase = envPassphrase, true
+			}
+		}
+		if hasPassphrase {
+			if err := validatePassphrase(passphrase, f); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		priv, err := generateKeyPair(curve)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to generate key: %v\n", err)
 			os.Exit(1)
 		}
 
-		kp, err := exportKeyPair(priv)
+		kp, err := exportKeyPair(priv, passphrase)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to export key: %v\n", err)
 			os.Exit(1)
@@ -83,10 +573,12 @@ func main() {
 
 	case "encrypt":
 		if len(os.Args) < 3 {
-			fmt.Fprintf(os.Stderr, "Usage: %s encrypt <base64-pubkey-pem>\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "Usage: %s encrypt <base64-pubkey-pem> [--chunked[=N]]\n", os.Args[0])
 			os.Exit(1)
 		}
 
+		_, f := parseArgs(os.Args[3:])
+
 		pubPEM, err := base64.StdEncoding.DecodeString(os.Args[2])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to decode public key: %v\n", err)
@@ -99,65 +591,204 @@ func main() {
 			os.Exit(1)
 		}
 
-		pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		ecPub, err := parseECDSAPublicKeyPEM(block)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to parse public key: %v\n", err)
 			os.Exit(1)
 		}
 
-		ecPub, ok := pubKey.(*ecdsa.PublicKey)
+		curveID, ok := idForCurve(ecPub.Curve)
 		if !ok {
-			fmt.Fprintf(os.Stderr, "Not an ECDSA public key\n")
+			curveName := ecPub.Curve.Params().Name
+			fmt.Fprintf(os.Stderr, "Invalid key curve: expected one of P-256, P-384, P-521, got %s\n", curveName)
 			os.Exit(1)
 		}
 
-		if ecPub.Curve != elliptic.P256() {
-			curveName := ecPub.Curve.Params().Name
-			fmt.Fprintf(os.Stderr, "Invalid key curve: expected P-256, got %s\n", curveName)
-			os.Exit(1)
+		chunked, chunkSize := false, defaultChunkSize
+		if v, ok := f["chunked"]; ok {
+			chunked = true
+			if v != "true" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					fmt.Fprintf(os.Stderr, "Invalid --chunked size: %q\n", v)
+					os.Exit(1)
+				}
+				chunkSize = n
+			}
 		}
 
-		_, err = crypto.EncryptFIPSKEMDEMStream(ecPub, os.Stdin, os.Stdout)
-		if err != nil {
+		// go-common's crypto.EncryptFIPSKEMDEMStream only ever accepts P-256 recipients, so any
+		// other curve has to go through kemdem regardless of --chunked; --chunked on a P-256 key
+		// also goes through kemdem, since that's what gives it a configurable frame size.
+		useKemdem := chunked || ecPub.Curve != elliptic.P256()
+
+		frameMode := frameModeStream
+		if useKemdem {
+			frameMode = frameModeKemdem
+		}
+		if _, err := os.Stdout.Write([]byte{curveID, frameMode}); err != nil {
 			fmt.Fprintf(os.Stderr, "Encryption failed: %v\n", err)
 			os.Exit(1)
 		}
 
+		if useKemdem {
+			size := 0
+			if chunked {
+				size = chunkSize
+			}
+			if _, err := kemdem.EncryptStream(ecPub, size, os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Encryption failed: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			_, err = crypto.EncryptFIPSKEMDEMStream(ecPub, os.Stdin, os.Stdout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Encryption failed: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
 	case "decrypt":
 		if len(os.Args) < 3 {
 			fmt.Fprintf(os.Stderr, "Usage: %s decrypt <base64-privkey-pem>\n", os.Args[0])
 			os.Exit(1)
 		}
 
-		privPEM, err := base64.StdEncoding.DecodeString(os.Args[2])
+		_, f := parseArgs(os.Args[3:])
+
+		privKey, err := loadPrivateKeyPEM(os.Args[2], f)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to decode private key: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to load private key: %v\n", err)
 			os.Exit(1)
 		}
 
-		block, _ := pem.Decode(privPEM)
+		curveID, ok := idForCurve(privKey.Curve)
+		if !ok {
+			curveName := privKey.Curve.Params().Name
+			fmt.Fprintf(os.Stderr, "Invalid key curve: expected one of P-256, P-384, P-521, got %s\n", curveName)
+			os.Exit(1)
+		}
+
+		var header [2]byte
+		if _, err := io.ReadFull(os.Stdin, header[:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read stream header: %v\n", err)
+			os.Exit(1)
+		}
+		streamCurveID, frameMode := header[0], header[1]
+		if streamCurveID != curveID {
+			streamCurve, ok := curveByID(streamCurveID)
+			streamCurveName := "unknown"
+			if ok {
+				streamCurveName = streamCurve.Params().Name
+			}
+			fmt.Fprintf(os.Stderr, "Invalid key curve: ciphertext was encrypted with %s, but private key is %s\n", streamCurveName, privKey.Curve.Params().Name)
+			os.Exit(1)
+		}
+
+		if frameMode == frameModeKemdem {
+			_, err = kemdem.DecryptStream(privKey, os.Stdin, os.Stdout)
+		} else {
+			_, err = crypto.DecryptFIPSKEMDEMStream(privKey, os.Stdin, os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Decryption failed: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "sign":
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s sign <base64-privkey-pem> [--raw] [--detached]\n", os.Args[0])
+			os.Exit(1)
+		}
+
+		_, f := parseArgs(os.Args[3:])
+
+		privKey, err := loadPrivateKeyPEM(os.Args[2], f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load private key: %v\n", err)
+			os.Exit(1)
+		}
+
+		if _, ok := idForCurve(privKey.Curve); !ok {
+			fmt.Fprintf(os.Stderr, "Invalid key curve: expected one of P-256, P-384, P-521, got %s\n", privKey.Curve.Params().Name)
+			os.Exit(1)
+		}
+
+		msg, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read message: %v\n", err)
+			os.Exit(1)
+		}
+		digest := sha256.Sum256(msg)
+
+		sig, err := ecdsa.SignASN1(rand.Reader, privKey, digest[:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signing failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		if _, ok := f["detached"]; ok {
+			enc := json.NewEncoder(os.Stdout)
+			if err := enc.Encode(signatureEnvelope{
+				Alg:   "ECDSA-SHA256",
+				Curve: privKey.Curve.Params().Name,
+				Sig:   base64.StdEncoding.EncodeToString(sig),
+				Hash:  base64.StdEncoding.EncodeToString(digest[:]),
+			}); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to encode JSON: %v\n", err)
+				os.Exit(1)
+			}
+		} else if _, ok := f["raw"]; ok {
+			if _, err := os.Stdout.Write(sig); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write signature: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Fprintln(os.Stdout, base64.StdEncoding.EncodeToString(sig))
+		}
+
+	case "verify":
+		if len(os.Args) < 4 {
+			fmt.Fprintf(os.Stderr, "Usage: %s verify <base64-pubkey-pem> <base64-sig>\n", os.Args[0])
+			os.Exit(1)
+		}
+
+		pubPEM, err := base64.StdEncoding.DecodeString(os.Args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to decode public key: %v\n", err)
+			os.Exit(1)
+		}
+
+		block, _ := pem.Decode(pubPEM)
 		if block == nil {
 			fmt.Fprintf(os.Stderr, "Failed to parse PEM block\n")
 			os.Exit(1)
 		}
 
-		privKey, err := x509.ParseECPrivateKey(block.Bytes)
+		ecPub, err := parseECDSAPublicKeyPEM(block)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to parse private key: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to parse public key: %v\n", err)
 			os.Exit(1)
 		}
 
-		if privKey.Curve != elliptic.P256() {
-			curveName := privKey.Curve.Params().Name
-			fmt.Fprintf(os.Stderr, "Invalid key curve: expected P-256, got %s\n", curveName)
+		sig, err := base64.StdEncoding.DecodeString(os.Args[3])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to decode signature: %v\n", err)
 			os.Exit(1)
 		}
 
-		_, err = crypto.DecryptFIPSKEMDEMStream(privKey, os.Stdin, os.Stdout)
+		msg, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Decryption failed: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to read message: %v\n", err)
+			os.Exit(1)
+		}
+		digest := sha256.Sum256(msg)
+
+		if !ecdsa.VerifyASN1(ecPub, digest[:], sig) {
+			fmt.Fprintln(os.Stderr, "Invalid signature")
 			os.Exit(1)
 		}
+		fmt.Fprintln(os.Stderr, "OK")
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
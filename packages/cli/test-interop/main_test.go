@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestEncryptDecryptPrivateKeyDERRoundTrip(t *testing.T) {
+	priv, err := generateKeyPair(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := priv.D.Bytes()
+
+	encrypted, err := encryptPrivateKeyDER(der, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("encryptPrivateKeyDER failed: %v", err)
+	}
+
+	decrypted, err := decryptPrivateKeyDER(encrypted, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("decryptPrivateKeyDER failed: %v", err)
+	}
+	if !bytes.Equal(der, decrypted) {
+		t.Fatal("decrypted DER doesn't match original")
+	}
+}
+
+func TestDecryptPrivateKeyDERWrongPassphrase(t *testing.T) {
+	encrypted, err := encryptPrivateKeyDER([]byte("super secret key material"), "right passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decryptPrivateKeyDER(encrypted, "wrong passphrase"); err == nil {
+		t.Fatal("expected decryption with the wrong passphrase to fail")
+	}
+}
+
+func TestDecryptPrivateKeyDERCorrupted(t *testing.T) {
+	encrypted, err := encryptPrivateKeyDER([]byte("super secret key material"), "right passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := append([]byte(nil), encrypted...)
+	corrupted[len(corrupted)-1] ^= 0xFF
+
+	if _, err := decryptPrivateKeyDER(corrupted, "right passphrase"); err == nil {
+		t.Fatal("expected decryption of corrupted data to fail")
+	}
+}
+
+func TestValidatePassphrase(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		flags      flags
+		wantErr    bool
+	}{
+		{"too short, default minimum", "short", flags{}, true},
+		{"meets default minimum", "twelve-characters!", flags{}, false},
+		{"custom minimum enforced", "abcde", flags{"min-passphrase-length": "5"}, false},
+		{"custom minimum still too short", "abcd", flags{"min-passphrase-length": "5"}, true},
+		{"invalid minimum flag", "whatever", flags{"min-passphrase-length": "not-a-number"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePassphrase(tt.passphrase, tt.flags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatePassphrase(%q, %v) error = %v, wantErr %v", tt.passphrase, tt.flags, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExportKeyPairEncryptedRoundTrip(t *testing.T) {
+	priv, err := generateKeyPair(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp, err := exportKeyPair(priv, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("exportKeyPair failed: %v", err)
+	}
+	if !strings.Contains(kp.PrivatePEM, "BEGIN "+pemTypeEncryptedPrivateKey) {
+		t.Fatalf("expected an encrypted private key PEM block, got:\n%s", kp.PrivatePEM)
+	}
+
+	loaded, err := loadPrivateKeyPEM(
+		base64.StdEncoding.EncodeToString([]byte(kp.PrivatePEM)),
+		flags{"passphrase": "correct horse battery staple"},
+	)
+	if err != nil {
+		t.Fatalf("loadPrivateKeyPEM failed: %v", err)
+	}
+	if loaded.D.Cmp(priv.D) != 0 {
+		t.Fatal("loaded private key doesn't match the original")
+	}
+}
+
+func TestExportKeyPairEncryptedWrongPassphrase(t *testing.T) {
+	priv, err := generateKeyPair(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp, err := exportKeyPair(priv, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = loadPrivateKeyPEM(base64.StdEncoding.EncodeToString([]byte(kp.PrivatePEM)), flags{"passphrase": "wrong passphrase"})
+	if err == nil {
+		t.Fatal("expected loading with the wrong passphrase to fail")
+	}
+}
+
+func TestParseECDSAPublicKeyPEM(t *testing.T) {
+	priv, err := generateKeyPair(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubBlock, err := pemBlockFromPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certBlock, err := pemBlockFromSelfSignedCert(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrBlock, err := pemBlockFromCSR(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		block *pem.Block
+	}{
+		{"bare PKIX public key", pubBlock},
+		{"certificate", certBlock},
+		{"certificate request", csrBlock},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseECDSAPublicKeyPEM(tt.block)
+			if err != nil {
+				t.Fatalf("parseECDSAPublicKeyPEM failed: %v", err)
+			}
+			if got.X.Cmp(priv.PublicKey.X) != 0 || got.Y.Cmp(priv.PublicKey.Y) != 0 {
+				t.Fatal("extracted public key doesn't match the original")
+			}
+		})
+	}
+}
+
+func TestParseECDSAPublicKeyPEMRejectsNonECDSA(t *testing.T) {
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(&rsaPriv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parseECDSAPublicKeyPEM(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+	if err == nil {
+		t.Fatal("expected an RSA public key to be rejected")
+	}
+}
+
+func TestParseECDSAPublicKeyPEMRejectsMalformed(t *testing.T) {
+	_, err := parseECDSAPublicKeyPEM(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("not a certificate")})
+	if err == nil {
+		t.Fatal("expected a malformed certificate to be rejected")
+	}
+}
+
+func pemBlockFromPKIXPublicKey(pub *ecdsa.PublicKey) (*pem.Block, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "PUBLIC KEY", Bytes: der}, nil
+}
+
+func pemBlockFromSelfSignedCert(priv *ecdsa.PrivateKey) (*pem.Block, error) {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-interop"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "CERTIFICATE", Bytes: der}, nil
+}
+
+func pemBlockFromCSR(priv *ecdsa.PrivateKey) (*pem.Block, error) {
+	tmpl := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "test-interop"}}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, priv)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}, nil
+}
+
+func TestParseAnyPrivateKey(t *testing.T) {
+	priv, err := generateKeyPair(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec1Der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("SEC1", func(t *testing.T) {
+		got, err := parseAnyPrivateKey(sec1Der)
+		if err != nil {
+			t.Fatalf("parseAnyPrivateKey failed: %v", err)
+		}
+		if got.D.Cmp(priv.D) != 0 {
+			t.Fatal("parsed key doesn't match the original")
+		}
+	})
+
+	t.Run("PKCS#8 ECDSA", func(t *testing.T) {
+		got, err := parseAnyPrivateKey(pkcs8Der)
+		if err != nil {
+			t.Fatalf("parseAnyPrivateKey failed: %v", err)
+		}
+		if got.D.Cmp(priv.D) != 0 {
+			t.Fatal("parsed key doesn't match the original")
+		}
+	})
+}
+
+func TestParseAnyPrivateKeyRejectsNonECDSA(t *testing.T) {
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDer, err := x509.MarshalPKCS8PrivateKey(rsaPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAnyPrivateKey(rsaDer); err == nil {
+		t.Fatal("expected an RSA key in a PKCS#8 container to be rejected")
+	}
+
+	_, ed25519Priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Der, err := x509.MarshalPKCS8PrivateKey(ed25519Priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAnyPrivateKey(ed25519Der); err == nil {
+		t.Fatal("expected an Ed25519 key in a PKCS#8 container to be rejected")
+	}
+}
+
+func TestParseAnyPrivateKeyRejectsMalformed(t *testing.T) {
+	if _, err := parseAnyPrivateKey([]byte("not a key")); err == nil {
+		t.Fatal("expected malformed DER to be rejected")
+	}
+}
+
+// TestMain builds the CLI once into a temp binary so the sign/verify tests below can drive it the
+// same way a real user would, exercising main()'s flag parsing and output formatting rather than
+// reimplementing it.
+var cliPath string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "test-interop-cli")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create temp dir: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	cliPath = filepath.Join(dir, "test-interop")
+	build := exec.Command("go", "build", "-o", cliPath, ".")
+	if out, err := build.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build CLI: %v\n%s\n", err, out)
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+func runCLI(t *testing.T, stdin []byte, args ...string) (stdout, stderr []byte, exitCode int) {
+	t.Helper()
+	cmd := exec.Command(cliPath, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err := cmd.Run()
+	exitCode = 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("failed to run CLI: %v", err)
+	}
+	return outBuf.Bytes(), errBuf.Bytes(), exitCode
+}
+
+func keygenForTest(t *testing.T) (pubB64, privB64 string) {
+	t.Helper()
+	out, _, code := runCLI(t, nil, "keygen", "--curve", "P-256")
+	if code != 0 {
+		t.Fatalf("keygen failed with exit code %d", code)
+	}
+	var kp KeyPair
+	if err := json.Unmarshal(out, &kp); err != nil {
+		t.Fatalf("failed to parse keygen output: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(kp.PublicPEM)), base64.StdEncoding.EncodeToString([]byte(kp.PrivatePEM))
+}
+
+func TestCLISignVerifyRoundTrip(t *testing.T) {
+	pub, priv := keygenForTest(t)
+	msg := []byte("the quick brown fox jumps over the lazy dog")
+
+	sigOut, _, code := runCLI(t, msg, "sign", priv)
+	if code != 0 {
+		t.Fatalf("sign failed with exit code %d", code)
+	}
+	sig := strings.TrimSpace(string(sigOut))
+
+	_, verifyErr, code := runCLI(t, msg, "verify", pub, sig)
+	if code != 0 {
+		t.Fatalf("verify failed with exit code %d: %s", code, verifyErr)
+	}
+	if !strings.Contains(string(verifyErr), "OK") {
+		t.Fatalf("expected OK, got: %s", verifyErr)
+	}
+}
+
+func TestCLIVerifyDetectsTamper(t *testing.T) {
+	pub, priv := keygenForTest(t)
+	msg := []byte("original message")
+
+	sigOut, _, code := runCLI(t, msg, "sign", priv)
+	if code != 0 {
+		t.Fatalf("sign failed with exit code %d", code)
+	}
+	sig := strings.TrimSpace(string(sigOut))
+
+	_, verifyErr, code := runCLI(t, []byte("tampered message"), "verify", pub, sig)
+	if code == 0 {
+		t.Fatal("expected verify to fail against a tampered message")
+	}
+	if !strings.Contains(string(verifyErr), "Invalid signature") {
+		t.Fatalf("expected an invalid-signature error, got: %s", verifyErr)
+	}
+}
+
+func TestCLISignDetached(t *testing.T) {
+	_, priv := keygenForTest(t)
+	msg := []byte("hello")
+
+	out, _, code := runCLI(t, msg, "sign", priv, "--detached")
+	if code != 0 {
+		t.Fatalf("sign --detached failed with exit code %d", code)
+	}
+
+	var env signatureEnvelope
+	if err := json.Unmarshal(out, &env); err != nil {
+		t.Fatalf("expected a JSON signature envelope, got: %s (err: %v)", out, err)
+	}
+	if env.Alg != "ECDSA-SHA256" || env.Curve != "P-256" {
+		t.Fatalf("unexpected envelope fields: %+v", env)
+	}
+	if env.Sig == "" || env.Hash == "" {
+		t.Fatalf("expected non-empty sig/hash, got: %+v", env)
+	}
+}
+
+func TestCLISignRaw(t *testing.T) {
+	_, priv := keygenForTest(t)
+	msg := []byte("hello")
+
+	out, _, code := runCLI(t, msg, "sign", priv, "--raw")
+	if code != 0 {
+		t.Fatalf("sign --raw failed with exit code %d", code)
+	}
+
+	// A raw ECDSA P-256 ASN.1 signature is a DER SEQUENCE, never valid base64-wrapped JSON or a
+	// bare base64 line with a trailing newline the way the default/--detached outputs are.
+	if bytes.HasSuffix(out, []byte("\n")) {
+		t.Fatal("expected raw signature bytes with no trailing newline")
+	}
+	if len(out) == 0 || out[0] != 0x30 {
+		t.Fatalf("expected a DER SEQUENCE (0x30 prefix), got: %x", out)
+	}
+}
+
+// TestDecryptPrivateKeyDEROmittedOptionalFields constructs a PBES2 EncryptedPrivateKeyInfo by
+// hand with PBKDF2-params' OPTIONAL keyLength and PRF fields both omitted, the way real-world
+// PKCS#8 tooling (e.g. `openssl pkcs8 -topk8 -v2 aes-256-cbc`) commonly does, and checks
+// decryptPrivateKeyDER still parses it and falls back to the RFC 8018 default PRF (hmacWithSHA1).
+func TestDecryptPrivateKeyDEROmittedOptionalFields(t *testing.T) {
+	passphrase := "right passphrase"
+	plaintext := []byte("super secret key material")
+
+	salt := bytes.Repeat([]byte{0x42}, pbkdf2SaltSize)
+	iv := bytes.Repeat([]byte{0x24}, aes.BlockSize)
+	iterations := 2048
+
+	key := pbkdf2.Key([]byte(passphrase), salt, iterations, aesKeySize, sha1.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded := pkcs7Pad(plaintext, aes.BlockSize)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	kdfParams, err := asn1.Marshal(struct {
+		Salt           []byte
+		IterationCount int
+	}{salt, iterations})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: algorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData: ciphertext,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decryptPrivateKeyDER(der, passphrase)
+	if err != nil {
+		t.Fatalf("decryptPrivateKeyDER failed on a key with omitted optional fields: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatal("decrypted data doesn't match original")
+	}
+}
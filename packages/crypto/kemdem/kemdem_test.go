@@ -0,0 +1,165 @@
+package kemdem
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestRoundTripPerCurve(t *testing.T) {
+	curves := []struct {
+		name  string
+		curve elliptic.Curve
+	}{
+		{"P-256", elliptic.P256()},
+		{"P-384", elliptic.P384()},
+		{"P-521", elliptic.P521()},
+	}
+
+	sizes := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"small", []byte("hello world")},
+		{"medium", bytes.Repeat([]byte("A"), 1000)},
+		{"large", bytes.Repeat([]byte("B"), 200000)},
+	}
+
+	for _, c := range curves {
+		t.Run(c.name, func(t *testing.T) {
+			priv, err := ecdsa.GenerateKey(c.curve, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, sz := range sizes {
+				t.Run(sz.name, func(t *testing.T) {
+					var encrypted bytes.Buffer
+					written, err := EncryptStream(&priv.PublicKey, 0, bytes.NewReader(sz.data), &encrypted)
+					if err != nil {
+						t.Fatalf("encryption failed: %v", err)
+					}
+					if written != int64(len(sz.data)) {
+						t.Fatalf("expected %d bytes written, got %d", len(sz.data), written)
+					}
+
+					var decrypted bytes.Buffer
+					read, err := DecryptStream(priv, &encrypted, &decrypted)
+					if err != nil {
+						t.Fatalf("decryption failed: %v", err)
+					}
+					if read != int64(len(sz.data)) {
+						t.Fatalf("expected %d bytes read, got %d", len(sz.data), read)
+					}
+					if !bytes.Equal(sz.data, decrypted.Bytes()) {
+						t.Fatal("decrypted data doesn't match original")
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestSmallFrameSize exercises multi-frame streams with a tiny --chunked-style frame size, the
+// path the CLI's --chunked=N flag drives.
+func TestSmallFrameSize(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("C"), 10000)
+
+	var encrypted bytes.Buffer
+	written, err := EncryptStream(&priv.PublicKey, 64, bytes.NewReader(data), &encrypted)
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	if written != int64(len(data)) {
+		t.Fatalf("expected %d bytes written, got %d", len(data), written)
+	}
+
+	var decrypted bytes.Buffer
+	read, err := DecryptStream(priv, &encrypted, &decrypted)
+	if err != nil {
+		t.Fatalf("decryption failed: %v", err)
+	}
+	if read != int64(len(data)) {
+		t.Fatalf("expected %d bytes read, got %d", len(data), read)
+	}
+	if !bytes.Equal(data, decrypted.Bytes()) {
+		t.Fatal("decrypted data doesn't match original")
+	}
+}
+
+// TestTruncatedStreamDetected verifies that a stream cut off mid-frame, or exactly on a frame
+// boundary before the is-last frame, is rejected rather than silently decrypted short.
+func TestTruncatedStreamDetected(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("D"), 1000)
+	var encrypted bytes.Buffer
+	if _, err := EncryptStream(&priv.PublicKey, 64, bytes.NewReader(data), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	full := encrypted.Bytes()
+	truncated := full[:len(full)-10]
+
+	var decrypted bytes.Buffer
+	_, err = DecryptStream(priv, bytes.NewReader(truncated), &decrypted)
+	if err == nil {
+		t.Fatal("expected an error decrypting a truncated stream")
+	}
+	if !strings.Contains(err.Error(), "truncated stream") {
+		t.Fatalf("expected a truncated-stream error, got: %v", err)
+	}
+}
+
+// TestWrongKeyRejected verifies that decrypting with a different recipient's private key fails.
+func TestWrongKeyRejected(t *testing.T) {
+	priv1, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv2, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encrypted bytes.Buffer
+	if _, err := EncryptStream(&priv1.PublicKey, 0, strings.NewReader("secret"), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	var decrypted bytes.Buffer
+	if _, err := DecryptStream(priv2, &encrypted, &decrypted); err == nil {
+		t.Fatal("expected decryption with the wrong key to fail")
+	}
+}
+
+func TestUnsupportedCurveRejected(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encrypted bytes.Buffer
+	_, err = EncryptStream(&priv.PublicKey, 0, strings.NewReader("data"), &encrypted)
+	if err == nil {
+		t.Fatal("expected P-224 to be rejected")
+	}
+
+	var decrypted bytes.Buffer
+	_, err = DecryptStream(priv, bytes.NewReader(nil), &decrypted)
+	if err == nil {
+		t.Fatal("expected P-224 to be rejected")
+	}
+}